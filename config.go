@@ -1,10 +1,15 @@
 package config
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -22,52 +27,158 @@ type Config struct {
 	Debug bool
 }
 
-// Lookup the env from |key| renamed to uppercase, hyphen is underscore, and return it or
-// the |defaultVal| in the type of |defaultVal|
-func lookupEnv(envNm string, defaultVal interface{}) (interface{}, error) {
-	var res interface{}
+type options struct {
+	envJSON string
+	dump    io.Writer
+	// sources is the provenance of each field by flag name, when not the struct default
+	sources map[string]string
+	// envPrefix prefixes the env names derived from flag names
+	envPrefix string
+}
 
-	if val, ok := os.LookupEnv(envNm); ok {
-		res = val
-	} else {
-		return defaultVal, nil
+func newOptions(opts []Option) *options {
+	o := &options{sources: map[string]string{}}
+	for _, opt := range opts {
+		opt(o)
 	}
-	switch t := defaultVal.(type) {
-	case int:
-		v, err := strconv.Atoi(res.(string))
-		if err != nil {
-			return nil, fmt.Errorf("%w, lookupEnv[%s]: %v\n", err, envNm, res)
-		}
-		return v, nil
-	case int64:
-		v, err := strconv.ParseInt(res.(string), 10, 64)
-		if err != nil {
-			return nil, fmt.Errorf("%w, lookupEnv[%s]: %v\n", err, envNm, res)
-		}
-		return v, nil
-	case float64:
-		v, err := strconv.ParseFloat(res.(string), 64)
-		if err != nil {
-			return nil, fmt.Errorf("%w, lookupEnv[%s]: %v\n", err, envNm, res)
-		}
-		return v, nil
-	case bool:
-		bstr := strings.ToUpper(res.(string))
-		if bstr == "TRUE" || bstr == "1" {
-			return true, nil
+	return o
+}
+
+// Option configures ReadConfig
+type Option func(*options)
+
+// WithEnvJSON json-unmarshals the single environment variable |envName|, when set, into the config
+// as a base layer. Individual environment variables and command-line flags still take precedence
+// per field. JSON keys follow encoding/json rules for the struct, i.e. `json` tags or field names,
+// and values may also be strings in the form of environment values, e.g. "30s" for a duration.
+func WithEnvJSON(envName string) Option {
+	return func(o *options) {
+		o.envJSON = envName
+	}
+}
+
+// WithStartupDump writes the effective config to |w| once ReadConfig has loaded it, one
+// `name=value [source]` line per field where source is one of default, env-json, env or flag.
+// Values of fields tagged `secret:"true"` are written as ***.
+// Only ReadConfig writes the dump; ReadSubcommand takes no options.
+func WithStartupDump(w io.Writer) Option {
+	return func(o *options) {
+		o.dump = w
+	}
+}
+
+// stripGroups removes the grouping separators, commas or underscores, of the integer part of the
+// number |s| of a field tagged `format:"grouped"`. Separators must all be the same and be placed
+// every three digits, e.g. 1,000,000 or 1_000_000.
+func stripGroups(s string) (string, error) {
+	intPart, rest := s, ""
+	if i := strings.IndexAny(s, ".eE"); i >= 0 {
+		intPart, rest = s[:i], s[i:]
+	}
+	sign := ""
+	if strings.HasPrefix(intPart, "-") || strings.HasPrefix(intPart, "+") {
+		sign, intPart = intPart[:1], intPart[1:]
+	}
+	if strings.ContainsAny(rest, ",_") {
+		return "", fmt.Errorf("misplaced grouping separator in %q", s)
+	}
+	i := strings.IndexAny(intPart, ",_")
+	if i < 0 {
+		return s, nil
+	}
+
+	groups := strings.Split(intPart, intPart[i:i+1])
+	if len(groups[0]) < 1 || len(groups[0]) > 3 {
+		return "", fmt.Errorf("misplaced grouping separator in %q", s)
+	}
+	for _, g := range groups[1:] {
+		if len(g) != 3 || strings.ContainsAny(g, ",_") {
+			return "", fmt.Errorf("misplaced grouping separator in %q", s)
 		}
-		return false, nil
-	case string:
-		return res, nil
-	case time.Duration:
-		v, err := time.ParseDuration(res.(string))
-		if err != nil {
-			return nil, fmt.Errorf("%w, lookupEnv[%s]: %v\n", err, envNm, res)
+	}
+	return sign + strings.Join(groups, "") + rest, nil
+}
+
+// groupedValue wraps a numeric flag.Value so that grouping separators are stripped before parsing
+type groupedValue struct {
+	flag.Value
+}
+
+// String of a zero groupedValue, as made by flag.PrintDefaults to detect zero defaults, is that
+// of the zero of the numeric types it wraps
+func (g *groupedValue) String() string {
+	if g.Value == nil {
+		return "0"
+	}
+	return g.Value.String()
+}
+
+func (g *groupedValue) Set(s string) error {
+	s, err := stripGroups(s)
+	if err != nil {
+		return err
+	}
+	return g.Value.Set(s)
+}
+
+// Lookup the env from |key| renamed to uppercase, hyphen is underscore, and return it or
+// the |defaultVal| in the type of |defaultVal|. When |grouped|, grouping separators are
+// stripped from numeric values before parsing.
+func lookupEnv(envNm string, defaultVal interface{}, grouped bool) (interface{}, error) {
+	val, ok := os.LookupEnv(envNm)
+	if !ok {
+		return defaultVal, nil
+	}
+	if defaultVal == nil {
+		return nil, fmt.Errorf("lookupEnv[%s]: unsupported type %v", envNm, defaultVal)
+	}
+	dst := reflect.New(reflect.TypeOf(defaultVal))
+	if err := parseValue(val, dst.Interface(), grouped); err != nil {
+		return nil, fmt.Errorf("%w, lookupEnv[%s]: %v\n", err, envNm, val)
+	}
+	return dst.Elem().Interface(), nil
+}
+
+// ParseValue converts |s| the same way environment values are converted and stores the result
+// in |dst|, a pointer to one of the supported field types: int, int64, uint64, float64, bool,
+// string or time.Duration.
+func ParseValue(s string, dst interface{}) error {
+	return parseValue(s, dst, false)
+}
+
+// parseValue is ParseValue stripping grouping separators from numeric values when |grouped|
+func parseValue(s string, dst interface{}, grouped bool) error {
+	if grouped {
+		switch dst.(type) {
+		case *int, *int64, *uint64, *float64:
+			var err error
+			if s, err = stripGroups(s); err != nil {
+				return err
+			}
 		}
-		return v, nil
+	}
+
+	var err error
+	switch t := dst.(type) {
+	case *int:
+		*t, err = strconv.Atoi(s)
+	case *int64:
+		*t, err = strconv.ParseInt(s, 10, 64)
+	case *uint64:
+		*t, err = strconv.ParseUint(s, 10, 64)
+	case *float64:
+		*t, err = strconv.ParseFloat(s, 64)
+	case *bool:
+		bstr := strings.ToUpper(s)
+		*t = bstr == "TRUE" || bstr == "1"
+	case *string:
+		*t = s
+	case *time.Duration:
+		*t, err = time.ParseDuration(s)
 	default:
-		return nil, fmt.Errorf("lookupEnv[%s]: unsupported type %v", envNm, t)
+		err = fmt.Errorf("unsupported type %T", dst)
 	}
+	return err
 }
 
 // ReadConfig loads config from command-line args (precedence) or environment
@@ -79,36 +190,299 @@ type MyConfig struct {
 	Debug     bool   `tag_name:"debug"`		// specially-named field
 }
 */
-func ReadConfig(cfg interface{}) error {
-	if err := readConfigWithFlagset(cfg, flag.CommandLine); err != nil {
+func ReadConfig(cfg interface{}, opts ...Option) error {
+	o := newOptions(opts)
+	if err := readConfig(cfg, flag.CommandLine, o); err != nil {
 		return err
 	}
 	flag.Parse()
+	if err := validateConfig(cfg); err != nil {
+		return err
+	}
+	if o.dump != nil {
+		return dumpConfig(o.dump, cfg, flag.CommandLine, o.sources)
+	}
 	return nil
 }
 
+// ReadSubcommand loads |global| from the leading flags of |args| (excluding the program name),
+// identifies the subcommand from the first positional and loads its config from the rest.
+// |sub| is a struct pointer whose fields are the config structs of each subcommand, named like
+// flags are, i.e. kebab-case of the field name or the `flag` tag. Env names derived for the fields
+// of a subcommand are prefixed with its name, e.g. SERVE_ADDR, while explicit `env` tags are used
+// as is. The remaining args are returned with the subcommand name first.
+/* Example:
+type Commands struct {
+	Serve   ServeConfig
+	Migrate *MigrateConfig
+}
+*/
+func ReadSubcommand(global, sub interface{}, args []string) (remaining []string, err error) {
+	gfs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	if err := readConfigWithFlagset(global, gfs); err != nil {
+		return nil, err
+	}
+	if err := gfs.Parse(args); err != nil {
+		return nil, err
+	}
+	if err := validateConfig(global); err != nil {
+		return nil, err
+	}
+
+	v := reflect.ValueOf(sub)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("subcommands argument is not a struct pointer")
+	}
+	cmds := map[string]reflect.Value{}
+	names := []string{}
+	val := v.Elem()
+	for i := 0; i < val.NumField(); i++ {
+		fValue := val.Field(i)
+		field := val.Type().Field(i)
+		if !fValue.CanSet() {
+			continue
+		}
+		name := strcase.ToKebab(field.Name)
+		if flagTag := field.Tag.Get("flag"); flagTag != "" {
+			if flagTag == "-" {
+				continue
+			}
+			name = flagTag
+		}
+		addr := fValue
+		if fValue.Kind() == reflect.Struct {
+			addr = fValue.Addr()
+		} else if fValue.Kind() != reflect.Ptr || fValue.IsNil() || fValue.Elem().Kind() != reflect.Struct {
+			continue
+		}
+		cmds[name] = addr
+		names = append(names, name)
+	}
+
+	pos := gfs.Args()
+	if len(pos) == 0 {
+		return nil, fmt.Errorf("missing subcommand, known subcommands: %s", strings.Join(names, ", "))
+	}
+	name := pos[0]
+	cmd, ok := cmds[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown subcommand %q, known subcommands: %s", name, strings.Join(names, ", "))
+	}
+
+	sfs := flag.NewFlagSet(name, flag.ContinueOnError)
+	so := newOptions(nil)
+	so.envPrefix = name + "-"
+	if err := readConfig(cmd.Interface(), sfs, so); err != nil {
+		return nil, fmt.Errorf("%w; %s: subcommand failure", err, name)
+	}
+	if err := sfs.Parse(pos[1:]); err != nil {
+		return nil, err
+	}
+	if err := validateConfig(cmd.Interface()); err != nil {
+		return nil, err
+	}
+
+	return append([]string{name}, sfs.Args()...), nil
+}
+
 // a util to be able to use a different flagset
-func readConfigWithFlagset(cfg interface{}, flagset *flag.FlagSet) error {
-	if err := readConfig(cfg, flagset); err != nil {
+func readConfigWithFlagset(cfg interface{}, flagset *flag.FlagSet, opts ...Option) error {
+	if err := readConfig(cfg, flagset, newOptions(opts)); err != nil {
 		return err
 	}
 	return nil
 }
 
-func readConfig(cfg interface{}, flagset *flag.FlagSet) error {
+func readConfig(cfg interface{}, flagset *flag.FlagSet, o *options) error {
 	v := reflect.ValueOf(cfg)
 	if v.Kind() != reflect.Ptr || v.IsNil() {
 		return fmt.Errorf("argument is not a struct pointer")
 	}
 
-	if err := reflectStruct(v, "", flagset); err != nil {
+	if o.envJSON != "" {
+		if val, ok := os.LookupEnv(o.envJSON); ok {
+			if err := unmarshalJSON([]byte(val), v, "", o.sources); err != nil {
+				var serr *json.SyntaxError
+				if errors.As(err, &serr) {
+					return fmt.Errorf("%w, malformed JSON in env %s", err, o.envJSON)
+				}
+				return fmt.Errorf("%w, JSON in env %s", err, o.envJSON)
+			}
+		}
+	}
+
+	if err := reflectStruct(v, "", flagset, o); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func reflectStruct(v reflect.Value, pfx string, flagset *flag.FlagSet) error {
+// unmarshalJSON json-unmarshals |data|, an object, into the struct pointed to by |v|. A JSON string
+// given for an int, int64, uint64, float64, bool or time.Duration field is converted like an environment
+// value, so that e.g. a duration may be "30s" as it may be in env or flags. Fields present in the
+// document are noted in |sources| by their flag name under |pfx|, unless |sources| is nil.
+func unmarshalJSON(data []byte, v reflect.Value, pfx string, sources map[string]string) error {
+	// reports syntax errors and non-object documents as encoding/json does
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	members, err := jsonMembers(data)
+	if err != nil {
+		return err
+	}
+
+	// JSON names of the settable fields, honoring the json struct tag
+	val := v.Elem()
+	names := make([]string, val.NumField())
+	for i := 0; i < val.NumField(); i++ {
+		field := val.Type().Field(i)
+		if !val.Field(i).CanSet() {
+			continue
+		}
+		name := field.Name
+		if jsonTag := field.Tag.Get("json"); jsonTag != "" {
+			if jsonTag == "-" {
+				continue
+			}
+			if n := strings.Split(jsonTag, ",")[0]; n != "" {
+				name = n
+			}
+		}
+		names[i] = name
+	}
+
+	// like encoding/json, members are applied in document order so the last of duplicate keys wins
+	for _, m := range members {
+		i := jsonField(names, m.key)
+		if i < 0 {
+			continue
+		}
+		fValue := val.Field(i)
+		field := val.Type().Field(i)
+
+		flagName, fpfx, flagOK := flagNameOf(field, pfx)
+		fSources := sources
+		if !flagOK {
+			fSources = nil
+		}
+		if fSources != nil {
+			fSources[flagName] = "env-json"
+		}
+		if string(m.raw) == "null" {
+			continue
+		}
+
+		if err := unmarshalJSONField(m.raw, field, fValue, fpfx, fSources); err != nil {
+			return fmt.Errorf("%w; %s: field failure", err, field.Name)
+		}
+	}
+
+	return nil
+}
+
+// jsonMember is a key and raw value of a JSON object
+type jsonMember struct {
+	key string
+	raw json.RawMessage
+}
+
+// jsonMembers returns the members of the JSON object |data| in document order
+func jsonMembers(data []byte) ([]jsonMember, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil || tok == nil {
+		// a null document has no members
+		return nil, err
+	}
+
+	var members []jsonMember
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		m := jsonMember{key: tok.(string)}
+		if err := dec.Decode(&m.raw); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, nil
+}
+
+// jsonField returns the index in |names| matching |key|, preferring an exact match like
+// encoding/json, or -1
+func jsonField(names []string, key string) int {
+	for i, name := range names {
+		if name != "" && name == key {
+			return i
+		}
+	}
+	for i, name := range names {
+		if name != "" && strings.EqualFold(name, key) {
+			return i
+		}
+	}
+	return -1
+}
+
+func unmarshalJSONField(raw json.RawMessage, field reflect.StructField, fValue reflect.Value, pfx string, sources map[string]string) error {
+	if _, ok := fValue.Addr().Interface().(json.Unmarshaler); ok {
+		return json.Unmarshal(raw, fValue.Addr().Interface())
+	}
+
+	// for a nested struct or struct pointer
+	if fValue.Kind() == reflect.Struct {
+		return unmarshalJSON(raw, fValue.Addr(), pfx, sources)
+	}
+	if fValue.Kind() == reflect.Ptr && field.Type.Elem().Kind() == reflect.Struct {
+		if fValue.IsNil() {
+			fValue.Set(reflect.New(field.Type.Elem()))
+		}
+		return unmarshalJSON(raw, fValue, pfx, sources)
+	}
+
+	if raw[0] == '"' {
+		switch fValue.Interface().(type) {
+		case int, int64, uint64, float64, bool, time.Duration:
+			var str string
+			if err := json.Unmarshal(raw, &str); err != nil {
+				return err
+			}
+			return parseValue(str, fValue.Addr().Interface(), field.Tag.Get("format") == "grouped")
+		}
+	}
+
+	return json.Unmarshal(raw, fValue.Addr().Interface())
+}
+
+// flagNameOf returns the derived flag name of |field| under |pfx| along with the prefix for the
+// fields of a nested struct, or false when the field is ignored
+func flagNameOf(field reflect.StructField, pfx string) (string, string, bool) {
+	// flag struct tag
+	flagName := strcase.ToKebab(pfx) + strcase.ToKebab(field.Name)
+	flagTag, flagTagOK := field.Tag.Lookup("flag")
+	if flagTag != "" {
+		if flagTag == "-" {
+			// the ignore tag
+			return "", "", false
+		}
+		flagName = strcase.ToKebab(pfx) + flagTag
+	}
+
+	fpfx := flagName + "-"
+	// an explicitly empty flagName on the nested structure means no prefix for its fields
+	if flagTagOK && flagTag == "" {
+		fpfx = ""
+	}
+	return flagName, fpfx, true
+}
+
+// walkStruct calls |fn| for each exported, non-ignored leaf field of the struct pointed to by |v|
+// with its derived flag name. Nested structs and non-nil struct pointers are traversed with their
+// flag name as a prefix for their fields.
+func walkStruct(v reflect.Value, pfx string, fn func(field reflect.StructField, fValue reflect.Value, flagName string) error) error {
 	val := v.Elem()
 
 	for i := 0; i < val.NumField(); i++ {
@@ -120,17 +494,9 @@ func reflectStruct(v reflect.Value, pfx string, flagset *flag.FlagSet) error {
 			continue
 		}
 
-		fTag := field.Tag
-
-		// flag struct tag
-		flagName := strcase.ToKebab(pfx) + strcase.ToKebab(field.Name)
-		flagTag, flagTagOK := fTag.Lookup("flag")
-		if flagTag != "" {
-			if flagTag == "-" {
-				// the ignore tag
-				continue
-			}
-			flagName = strcase.ToKebab(pfx) + flagTag
+		flagName, fpfx, ok := flagNameOf(field, pfx)
+		if !ok {
+			continue
 		}
 
 		// for a nested struct or struct pointer
@@ -138,71 +504,235 @@ func reflectStruct(v reflect.Value, pfx string, flagset *flag.FlagSet) error {
 			if fValue.Kind() == reflect.Ptr && fValue.IsNil() {
 				continue
 			}
-			fpfx := flagName + "-"
-			// an explicitly empty flagName on the nested structure means no prefix for its fields
-			if flagTagOK && flagTag == "" {
-				fpfx = ""
-			}
 			addr := fValue
 			if fValue.Kind() != reflect.Ptr {
 				addr = fValue.Addr()
 			} else if addr.Elem().Kind() != reflect.Struct {
 				continue
 			}
-			if err := reflectStruct(addr, fpfx, flagset); err != nil {
+			if err := walkStruct(addr, fpfx, fn); err != nil {
 				return fmt.Errorf("%w; %s: field failure", err, field.Name)
 			}
 			continue
 		}
 
-		// env struct tag and default value
-		defaultVal := fValue.Interface()
-		envName := ""
-		envTag, envTagOK := fTag.Lookup("env")
-		if envTagOK {
-			envName = envTag
-		} else {
-			envName = strcase.ToScreamingSnake(flagName)
+		if err := fn(field, fValue, flagName); err != nil {
+			return err
 		}
-		// envTag of "-" means do not consider OS environment variable
-		if envTag != "-" {
-			d, err := lookupEnv(envName, defaultVal)
-			if err != nil {
-				return err
+	}
+
+	return nil
+}
+
+func reflectStruct(v reflect.Value, pfx string, flagset *flag.FlagSet, o *options) error {
+	return walkStruct(v, pfx, func(field reflect.StructField, fValue reflect.Value, flagName string) error {
+		return registerField(field, fValue, flagName, flagset, o)
+	})
+}
+
+// registerField registers the flag for a leaf field with its env or current value as the default,
+// noting in the sources of |o| when the env was used
+func registerField(field reflect.StructField, fValue reflect.Value, flagName string, flagset *flag.FlagSet, o *options) error {
+	fTag := field.Tag
+
+	// format struct tag
+	grouped := fTag.Get("format") == "grouped"
+
+	// env struct tag and default value
+	defaultVal := fValue.Interface()
+	envName := ""
+	envTag, envTagOK := fTag.Lookup("env")
+	if envTagOK {
+		envName = envTag
+	} else {
+		envName = strcase.ToScreamingSnake(o.envPrefix + flagName)
+	}
+	// envTag of "-" means do not consider OS environment variable
+	if envTag != "-" {
+		d, err := lookupEnv(envName, defaultVal, grouped)
+		if err != nil {
+			return err
+		}
+		defaultVal = d
+		if _, ok := os.LookupEnv(envName); ok {
+			o.sources[flagName] = "env"
+		}
+	}
+
+	// usage struct tag
+	flagUsage := fTag.Get("usage")
+
+	if !fValue.CanAddr() {
+		return fmt.Errorf("unable to address field %s", field.Name)
+	}
+
+	switch field.Type.String() {
+	case "int":
+		x := fValue.Addr().Interface().(*int)
+		flagset.IntVar(x, flagName, defaultVal.(int), flagUsage)
+	case "int64":
+		x := fValue.Addr().Interface().(*int64)
+		flagset.Int64Var(x, flagName, defaultVal.(int64), flagUsage)
+	case "uint64":
+		x := fValue.Addr().Interface().(*uint64)
+		flagset.Uint64Var(x, flagName, defaultVal.(uint64), flagUsage)
+	case "float64":
+		x := fValue.Addr().Interface().(*float64)
+		flagset.Float64Var(x, flagName, defaultVal.(float64), flagUsage)
+	case "string":
+		x := fValue.Addr().Interface().(*string)
+		flagset.StringVar(x, flagName, defaultVal.(string), flagUsage)
+	case "bool":
+		x := fValue.Addr().Interface().(*bool)
+		flagset.BoolVar(x, flagName, defaultVal.(bool), flagUsage)
+	case "time.Duration":
+		x := fValue.Addr().Interface().(*time.Duration)
+		flagset.DurationVar(x, flagName, defaultVal.(time.Duration), flagUsage)
+	default:
+		return fmt.Errorf("unsuported struct type %s", field.Type.String())
+	}
+
+	if grouped {
+		switch defaultVal.(type) {
+		case int, int64, uint64, float64:
+			f := flagset.Lookup(flagName)
+			// flag.UnquoteUsage only knows the type name of the unwrapped value, so keep it as a
+			// backquoted name in the usage unless the usage already names one
+			if !strings.Contains(f.Usage, "`") {
+				typeName, _ := flag.UnquoteUsage(f)
+				f.Usage = strings.TrimSpace(f.Usage + " (`" + typeName + "` with optional grouping separators)")
 			}
-			defaultVal = d
-		}
-
-		// usage struct tag
-		flagUsage := fTag.Get("usage")
-
-		if !fValue.CanAddr() {
-			return fmt.Errorf("unable to address field %s", field.Name)
-		}
-
-		switch field.Type.String() {
-		case "int":
-			x := fValue.Addr().Interface().(*int)
-			flagset.IntVar(x, flagName, defaultVal.(int), flagUsage)
-		case "int64":
-			x := fValue.Addr().Interface().(*int64)
-			flagset.Int64Var(x, flagName, defaultVal.(int64), flagUsage)
-		case "float64":
-			x := fValue.Addr().Interface().(*float64)
-			flagset.Float64Var(x, flagName, defaultVal.(float64), flagUsage)
-		case "string":
-			x := fValue.Addr().Interface().(*string)
-			flagset.StringVar(x, flagName, defaultVal.(string), flagUsage)
-		case "bool":
-			x := fValue.Addr().Interface().(*bool)
-			flagset.BoolVar(x, flagName, defaultVal.(bool), flagUsage)
-		case "time.Duration":
-			x := fValue.Addr().Interface().(*time.Duration)
-			flagset.DurationVar(x, flagName, defaultVal.(time.Duration), flagUsage)
-		default:
-			return fmt.Errorf("unsuported struct type %s", field.Type.String())
+			f.Value = &groupedValue{f.Value}
 		}
 	}
 
 	return nil
 }
+
+// validateConfig checks the resolved values of |cfg| against the `multipleOf` and `powerOfTwo`
+// struct tags of its int and int64 fields
+func validateConfig(cfg interface{}) error {
+	return walkStruct(reflect.ValueOf(cfg), "", func(field reflect.StructField, fValue reflect.Value, flagName string) error {
+		multTag, multOK := field.Tag.Lookup("multipleOf")
+		pow2 := field.Tag.Get("powerOfTwo") == "true"
+		if !multOK && !pow2 {
+			return nil
+		}
+
+		var n int64
+		switch t := fValue.Interface().(type) {
+		case int:
+			n = int64(t)
+		case int64:
+			n = t
+		default:
+			return fmt.Errorf("%s: multipleOf and powerOfTwo unsupported on type %s", field.Name, field.Type.String())
+		}
+
+		if multOK {
+			m, err := strconv.ParseInt(multTag, 10, 64)
+			if err != nil || m <= 0 {
+				return fmt.Errorf("%s: invalid multipleOf tag %q", field.Name, multTag)
+			}
+			if n%m != 0 {
+				return fmt.Errorf("%s: value %d is not a multiple of %d", field.Name, n, m)
+			}
+		}
+		if pow2 && (n <= 0 || n&(n-1) != 0) {
+			return fmt.Errorf("%s: value %d is not a power of two", field.Name, n)
+		}
+		return nil
+	})
+}
+
+// redactedValue replaces the values of fields tagged `secret:"true"` when redacting
+const redactedValue = "***"
+
+// dumpConfig writes the effective config of |cfg| to |w| annotated with the source of each value.
+// Values of secret fields are redacted.
+func dumpConfig(w io.Writer, cfg interface{}, flagset *flag.FlagSet, sources map[string]string) error {
+	flagset.Visit(func(f *flag.Flag) {
+		sources[f.Name] = "flag"
+	})
+
+	m := AsRedactedMap(cfg)
+	names := make([]string, 0, len(m))
+	for k := range m {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		src, ok := sources[k]
+		if !ok {
+			src = "default"
+		}
+		if _, err := fmt.Fprintf(w, "%s=%s [%s]\n", k, m[k], src); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AsMap returns the derived flag name and canonical string value of every supported field of
+// |cfg|, a struct or struct pointer, recursing into nested structs with prefixed names like
+// ReadConfig. Values are formatted so they parse back as env or flag values.
+func AsMap(cfg interface{}) map[string]string {
+	return asMap(cfg, false)
+}
+
+// AsRedactedMap is AsMap with the values of fields tagged `secret:"true"` rendered as ***
+func AsRedactedMap(cfg interface{}) map[string]string {
+	return asMap(cfg, true)
+}
+
+// asMap renders the values of fields tagged `secret:"true"` as redactedValue when |redact|
+func asMap(cfg interface{}, redact bool) map[string]string {
+	res := map[string]string{}
+
+	v := reflect.ValueOf(cfg)
+	if !v.IsValid() {
+		return res
+	}
+	if v.Kind() != reflect.Ptr {
+		p := reflect.New(v.Type())
+		p.Elem().Set(v)
+		v = p
+	}
+	if v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return res
+	}
+
+	_ = walkStruct(v, "", func(field reflect.StructField, fValue reflect.Value, flagName string) error {
+		if s, ok := formatValue(fValue.Interface()); ok {
+			if redact && field.Tag.Get("secret") == "true" {
+				s = redactedValue
+			}
+			res[flagName] = s
+		}
+		return nil
+	})
+
+	return res
+}
+
+// formatValue returns the canonical string of a supported field value
+func formatValue(val interface{}) (string, bool) {
+	switch t := val.(type) {
+	case int:
+		return strconv.Itoa(t), true
+	case int64:
+		return strconv.FormatInt(t, 10), true
+	case uint64:
+		return strconv.FormatUint(t, 10), true
+	case float64:
+		return strconv.FormatFloat(t, 'g', -1, 64), true
+	case bool:
+		return strconv.FormatBool(t), true
+	case string:
+		return t, true
+	case time.Duration:
+		return t.String(), true
+	default:
+		return "", false
+	}
+}
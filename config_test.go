@@ -1,6 +1,7 @@
 package config
 
 import (
+	"bytes"
 	"flag"
 	"net/http"
 	"os"
@@ -152,4 +153,356 @@ func TestConfig(t *testing.T) {
 		foundAll := checkFlags(flags, []string{"name", "street", "postcode", "addr-street", "addr-postcode"})
 		So(foundAll, ShouldBeTrue)
 	})
+	Convey("Grouped numbers", t, func() {
+		type Ss1 struct {
+			MaxRows   int     `format:"grouped"`
+			MaxBytes  int64   `format:"grouped"`
+			Ratio     float64 `format:"grouped"`
+			PlainRows int
+		}
+		ss := Ss1{}
+		os.Setenv("MAX_ROWS", "1,000,000")
+		os.Setenv("MAX_BYTES", "1_000_000")
+		os.Setenv("PLAIN_ROWS", "1,000")
+		fs := flag.NewFlagSet("cmd", flag.ContinueOnError)
+		err := readConfigWithFlagset(&ss, fs)
+		So(err, ShouldNotBeNil) // PLAIN_ROWS is not grouped
+		os.Unsetenv("PLAIN_ROWS")
+
+		ss = Ss1{}
+		fs = flag.NewFlagSet("cmd", flag.ContinueOnError)
+		err = readConfigWithFlagset(&ss, fs)
+		So(err, ShouldBeNil)
+		So(ss.MaxRows, ShouldEqual, 1000000)
+		So(ss.MaxBytes, ShouldEqual, 1000000)
+
+		// usage keeps the type name of the wrapped flag
+		typeName, usage := flag.UnquoteUsage(fs.Lookup("max-rows"))
+		So(typeName, ShouldEqual, "int")
+		So(usage, ShouldEqual, "(int with optional grouping separators)")
+		typeName, _ = flag.UnquoteUsage(fs.Lookup("ratio"))
+		So(typeName, ShouldEqual, "float")
+
+		err = fs.Parse([]string{"-max-rows", "2_000_000", "-ratio", "1,234.5"})
+		So(err, ShouldBeNil)
+		So(ss.MaxRows, ShouldEqual, 2000000)
+		So(ss.Ratio, ShouldEqual, 1234.5)
+
+		// separators must be every three digits
+		for _, bad := range []string{"1,0,0", "1000,000", "1,000_000", ",100", "1,000,"} {
+			So(fs.Parse([]string{"-max-rows", bad}), ShouldNotBeNil)
+		}
+		So(fs.Parse([]string{"-max-rows", "-1,000", "-ratio", "-12_345.678_9"}), ShouldNotBeNil)
+		So(fs.Parse([]string{"-max-rows", "-1,000", "-ratio", "-12_345.5e3"}), ShouldBeNil)
+		So(ss.MaxRows, ShouldEqual, -1000)
+		So(ss.Ratio, ShouldEqual, -12345.5e3)
+		os.Unsetenv("MAX_ROWS")
+		os.Unsetenv("MAX_BYTES")
+
+		// zero defaults are not printed
+		ss = Ss1{MaxBytes: 1000}
+		fs = flag.NewFlagSet("cmd", flag.ContinueOnError)
+		So(readConfigWithFlagset(&ss, fs), ShouldBeNil)
+		var out bytes.Buffer
+		fs.SetOutput(&out)
+		fs.PrintDefaults()
+		So(out.String(), ShouldEqual, `  -max-bytes int
+    	(int with optional grouping separators) (default 1000)
+  -max-rows int
+    	(int with optional grouping separators)
+  -plain-rows int
+    	
+  -ratio float
+    	(float with optional grouping separators)
+`)
+	})
+	Convey("Env JSON document", t, func() {
+		type Ss2 struct {
+			Street string
+			Zip    string `json:"postcode"`
+		}
+		type Ss1 struct {
+			Name    string
+			Age     int
+			Timeout time.Duration
+			Retry   time.Duration
+			Addr    Ss2
+			Addr2   *Ss2 `flag:"-"`
+		}
+		ss := Ss1{Name: "default"}
+		os.Setenv("APP_CONFIG", `{"Name": "John", "Age": 7, "timeout": "30s", "Retry": 1000000000,
+			"Addr": {"Street": "145 Hogarth Ln", "postcode": "w68rx"}, "Addr2": {"Street": "Old Rd"}}`)
+		os.Setenv("AGE", "8") // individual env wins
+		fs := flag.NewFlagSet("cmd", flag.ContinueOnError)
+		err := readConfigWithFlagset(&ss, fs, WithEnvJSON("APP_CONFIG"))
+		So(err, ShouldBeNil)
+		err = fs.Parse([]string{"-name", "Jane"}) // flag wins
+		So(err, ShouldBeNil)
+		So(ss.Name, ShouldEqual, "Jane")
+		So(ss.Age, ShouldEqual, 8)
+		So(ss.Addr.Street, ShouldEqual, "145 Hogarth Ln")
+		So(ss.Addr.Zip, ShouldEqual, "w68rx")
+		So(ss.Addr2.Street, ShouldEqual, "Old Rd")
+		So(ss.Timeout, ShouldEqual, 30*time.Second)
+		So(ss.Retry, ShouldEqual, time.Second)
+		os.Unsetenv("AGE")
+
+		os.Setenv("APP_CONFIG", `{"Name": `)
+		fs = flag.NewFlagSet("cmd", flag.ContinueOnError)
+		err = readConfigWithFlagset(&ss, fs, WithEnvJSON("APP_CONFIG"))
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "malformed JSON in env APP_CONFIG")
+
+		// keys are applied in document order, the last of keys differing only in case wins
+		os.Unsetenv("ADDR_STREET") // set by an earlier test
+		for i := 0; i < 10; i++ {
+			os.Setenv("APP_CONFIG", `{"name": "first", "NAME": "second", "Addr": {"street": "a"}, "addr": {"postcode": "b"}, "Age": null}`)
+			ss = Ss1{}
+			fs = flag.NewFlagSet("cmd", flag.ContinueOnError)
+			So(readConfigWithFlagset(&ss, fs, WithEnvJSON("APP_CONFIG")), ShouldBeNil)
+			So(ss.Name, ShouldEqual, "second")
+			So(ss.Addr.Street, ShouldEqual, "a")
+			So(ss.Addr.Zip, ShouldEqual, "b")
+		}
+		os.Setenv("APP_CONFIG", `null`)
+		ss = Ss1{Name: "default"}
+		fs = flag.NewFlagSet("cmd", flag.ContinueOnError)
+		So(readConfigWithFlagset(&ss, fs, WithEnvJSON("APP_CONFIG")), ShouldBeNil)
+		So(ss.Name, ShouldEqual, "default")
+
+		// well-formed JSON of the wrong type names the field
+		for _, doc := range []string{`{"Age": "seven"}`, `{"Age": true}`, `{"Addr": {"Street": 5}}`} {
+			os.Setenv("APP_CONFIG", doc)
+			fs = flag.NewFlagSet("cmd", flag.ContinueOnError)
+			err = readConfigWithFlagset(&ss, fs, WithEnvJSON("APP_CONFIG"))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldNotContainSubstring, "malformed")
+			So(err.Error(), ShouldContainSubstring, "field failure")
+			So(err.Error(), ShouldContainSubstring, "APP_CONFIG")
+		}
+		os.Unsetenv("APP_CONFIG")
+	})
+	Convey("As map", t, func() {
+		type Ss2 struct {
+			Street string
+			Zip    string `flag:"postcode"`
+		}
+		type Ss1 struct {
+			Name    string
+			Age     int
+			Ratio   float64
+			Swimmer bool
+			Timeout time.Duration
+			Addr    Ss2
+			Addr2   *Ss2         `flag:""`
+			Client  *http.Client `flag:"-"`
+			Token   string       `secret:"true"`
+			private string
+		}
+		ss := Ss1{
+			Name:    "John",
+			Age:     7,
+			Ratio:   0.5,
+			Swimmer: true,
+			Timeout: time.Minute,
+			Addr:    Ss2{Street: "145 Hogarth Ln", Zip: "w68rx"},
+			Addr2:   &Ss2{Zip: "10001"},
+			Token:   "s3cr3t",
+		}
+		m := AsMap(&ss)
+		So(m, ShouldResemble, map[string]string{
+			"name":          "John",
+			"age":           "7",
+			"ratio":         "0.5",
+			"swimmer":       "true",
+			"timeout":       "1m0s",
+			"addr-street":   "145 Hogarth Ln",
+			"addr-postcode": "w68rx",
+			"street":        "",
+			"postcode":      "10001",
+			"token":         "s3cr3t",
+		})
+		So(AsMap(ss), ShouldResemble, m)
+
+		// only the secret is redacted
+		rm := AsRedactedMap(&ss)
+		So(rm["token"], ShouldEqual, "***")
+		delete(rm, "token")
+		for k, v := range rm {
+			So(m[k], ShouldEqual, v)
+		}
+		So(len(rm), ShouldEqual, len(m)-1)
+		So(AsRedactedMap(nil), ShouldBeEmpty)
+		So(AsMap(nil), ShouldBeEmpty)
+		So(AsMap((*Ss1)(nil)), ShouldBeEmpty)
+		So(AsMap(7), ShouldBeEmpty)
+
+		// round trip through env
+		for k, v := range m {
+			os.Setenv(strcase.ToScreamingSnake(k), v)
+		}
+		rt := Ss1{Addr2: &Ss2{}}
+		fs := flag.NewFlagSet("cmd", flag.ContinueOnError)
+		err := readConfigWithFlagset(&rt, fs)
+		So(err, ShouldBeNil)
+		So(AsMap(&rt), ShouldResemble, m)
+		for k := range m {
+			os.Unsetenv(strcase.ToScreamingSnake(k))
+		}
+	})
+	Convey("Multiple of", t, func() {
+		type Ss2 struct {
+			PageSize int `powerOfTwo:"true"`
+		}
+		type Ss1 struct {
+			BufferSize int64 `multipleOf:"4096"`
+			Cache      Ss2
+		}
+		ss := Ss1{BufferSize: 8192, Cache: Ss2{PageSize: 64}}
+		fs := flag.NewFlagSet("cmd", flag.ContinueOnError)
+		err := readConfigWithFlagset(&ss, fs)
+		So(err, ShouldBeNil)
+		So(validateConfig(&ss), ShouldBeNil)
+
+		So(fs.Parse([]string{"-buffer-size", "5000"}), ShouldBeNil)
+		err = validateConfig(&ss)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "BufferSize")
+
+		So(fs.Parse([]string{"-buffer-size", "4096", "-cache-page-size", "48"}), ShouldBeNil)
+		err = validateConfig(&ss)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "PageSize")
+
+		type Ss3 struct {
+			Name string `multipleOf:"2"`
+		}
+		So(validateConfig(&Ss3{}), ShouldNotBeNil)
+	})
+	Convey("Subcommands", t, func() {
+		type Global struct {
+			Verbose bool
+		}
+		type Serve struct {
+			Addr    string
+			Verbose bool
+			Token   string `env:"API_TOKEN" secret:"true"`
+		}
+		type Migrate struct {
+			Steps int
+		}
+		type Commands struct {
+			Serve   Serve
+			Migrate *Migrate `flag:"db-migrate"`
+			Unset   *Serve
+		}
+		g := Global{}
+		cmds := Commands{Serve: Serve{Addr: ":80"}, Migrate: &Migrate{}}
+		rem, err := ReadSubcommand(&g, &cmds, []string{"-verbose", "db-migrate", "-steps", "3", "up"})
+		So(err, ShouldBeNil)
+		So(rem, ShouldResemble, []string{"db-migrate", "up"})
+		So(g.Verbose, ShouldBeTrue)
+		So(cmds.Migrate.Steps, ShouldEqual, 3)
+		So(cmds.Serve.Addr, ShouldEqual, ":80")
+
+		rem, err = ReadSubcommand(&g, &cmds, []string{"serve", "-addr", ":8080"})
+		So(err, ShouldBeNil)
+		So(rem, ShouldResemble, []string{"serve"})
+		So(cmds.Serve.Addr, ShouldEqual, ":8080")
+
+		// subcommand env names are prefixed with the subcommand name
+		os.Setenv("SERVE_ADDR", ":9090")
+		os.Setenv("SERVE_VERBOSE", "true")
+		os.Setenv("API_TOKEN", "secret")
+		os.Setenv("ADDR", ":1")
+		g = Global{}
+		cmds = Commands{}
+		_, err = ReadSubcommand(&g, &cmds, []string{"serve"})
+		So(err, ShouldBeNil)
+		So(g.Verbose, ShouldBeFalse)
+		So(cmds.Serve.Verbose, ShouldBeTrue)
+		So(cmds.Serve.Addr, ShouldEqual, ":9090")
+		So(cmds.Serve.Token, ShouldEqual, "secret")
+		os.Unsetenv("SERVE_ADDR")
+		os.Unsetenv("SERVE_VERBOSE")
+		os.Unsetenv("API_TOKEN")
+		os.Unsetenv("ADDR")
+		cmds = Commands{Migrate: &Migrate{}}
+
+		_, err = ReadSubcommand(&g, &cmds, []string{"unset"})
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "serve, db-migrate")
+
+		_, err = ReadSubcommand(&g, &cmds, []string{})
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Startup dump", t, func() {
+		type Ss2 struct {
+			Addr string
+		}
+		type Ss1 struct {
+			Name    string
+			Age     int
+			Debug   bool
+			Timeout time.Duration
+			Server  Ss2
+			Token   string `secret:"true" env:"API_TOKEN"`
+			Pin     int    `secret:"true"`
+		}
+		ss := Ss1{Timeout: time.Second, Server: Ss2{Addr: ":80"}}
+		os.Setenv("APP_CONFIG", `{"Name": "John", "Age": 7, "Timeout": "1s", "Server": {}}`) // Timeout is the default
+		os.Setenv("SERVER_ADDR", ":8080")
+		os.Setenv("AGE", "8")
+		os.Setenv("API_TOKEN", "s3cr3t")
+		var buf bytes.Buffer
+		o := newOptions([]Option{WithEnvJSON("APP_CONFIG"), WithStartupDump(&buf)})
+		fs := flag.NewFlagSet("cmd", flag.ContinueOnError)
+		err := readConfig(&ss, fs, o)
+		So(err, ShouldBeNil)
+		So(fs.Parse([]string{"-debug"}), ShouldBeNil)
+		err = dumpConfig(o.dump, &ss, fs, o.sources)
+		So(err, ShouldBeNil)
+		So(buf.String(), ShouldEqual, `age=8 [env]
+debug=true [flag]
+name=John [env-json]
+pin=*** [default]
+server-addr=:8080 [env]
+timeout=1s [env-json]
+token=*** [env]
+`)
+		So(buf.String(), ShouldNotContainSubstring, "s3cr3t")
+		So(ss.Token, ShouldEqual, "s3cr3t")
+		os.Unsetenv("API_TOKEN")
+		os.Unsetenv("APP_CONFIG")
+		os.Unsetenv("SERVER_ADDR")
+		os.Unsetenv("AGE")
+	})
+	Convey("Parse value", t, func() {
+		var u uint64
+		So(ParseValue("18446744073709551615", &u), ShouldBeNil)
+		So(u, ShouldEqual, uint64(18446744073709551615))
+		var d time.Duration
+		So(ParseValue("30s", &d), ShouldBeNil)
+		So(d, ShouldEqual, 30*time.Second)
+		var b bool
+		So(ParseValue("1", &b), ShouldBeNil)
+		So(b, ShouldBeTrue)
+		var i int
+		So(ParseValue("1,000", &i), ShouldNotBeNil) // no grouping outside of the format tag
+		var i32 int32
+		So(ParseValue("1", &i32), ShouldNotBeNil)
+
+		type Ss1 struct {
+			Inodes uint64 `format:"grouped"`
+		}
+		ss := Ss1{}
+		os.Setenv("INODES", "18,446,744,073,709,551,615")
+		fs := flag.NewFlagSet("cmd", flag.ContinueOnError)
+		So(readConfigWithFlagset(&ss, fs), ShouldBeNil)
+		So(ss.Inodes, ShouldEqual, uint64(18446744073709551615))
+		So(AsMap(&ss)["inodes"], ShouldEqual, "18446744073709551615")
+		So(fs.Parse([]string{"-inodes", "1_000"}), ShouldBeNil)
+		So(ss.Inodes, ShouldEqual, 1000)
+		os.Unsetenv("INODES")
+	})
 }
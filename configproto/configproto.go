@@ -0,0 +1,229 @@
+// Package configproto loads a protobuf message from command-line args (precedence) or environment
+// using the same naming, layering and converters as package config. It is its own module so that
+// the protobuf dependency is only pulled in by applications that need it.
+package configproto
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/dsggregory/config"
+	"github.com/iancoleman/strcase"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+type options struct {
+	flagset *flag.FlagSet
+	args    []string
+}
+
+// Option configures ReadConfigProto. It is distinct from config.Option, which does not apply here.
+type Option func(*options)
+
+// WithFlagSet registers and parses flags on |fs| instead of flag.CommandLine
+func WithFlagSet(fs *flag.FlagSet) Option {
+	return func(o *options) {
+		o.flagset = fs
+	}
+}
+
+// WithArgs parses |args| instead of os.Args[1:]
+func WithArgs(args []string) Option {
+	return func(o *options) {
+		o.args = args
+	}
+}
+
+// fieldValue is a flag.Value setting a scalar field of a proto message
+type fieldValue struct {
+	msg protoreflect.Message
+	fd  protoreflect.FieldDescriptor
+}
+
+func (v *fieldValue) String() string {
+	if v.msg == nil {
+		return ""
+	}
+	val := v.msg.Get(v.fd)
+	if v.fd.Kind() == protoreflect.EnumKind {
+		if ev := v.fd.Enum().Values().ByNumber(val.Enum()); ev != nil {
+			return string(ev.Name())
+		}
+		return strconv.Itoa(int(val.Enum()))
+	}
+	return val.String()
+}
+
+func (v *fieldValue) Set(s string) error {
+	val, err := parseScalar(v.fd, s)
+	if err != nil {
+		return err
+	}
+	v.msg.Set(v.fd, val)
+	return nil
+}
+
+// IsBoolFlag allows bool fields to be set with a bare flag
+func (v *fieldValue) IsBoolFlag() bool {
+	return v.fd.Kind() == protoreflect.BoolKind
+}
+
+// parseScalar converts |s| into a value of the kind of |fd| using the converters of package config
+func parseScalar(fd protoreflect.FieldDescriptor, s string) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		var v bool
+		err := config.ParseValue(s, &v)
+		return protoreflect.ValueOfBool(v), err
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(s), nil
+	case protoreflect.BytesKind:
+		return protoreflect.ValueOfBytes([]byte(s)), nil
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		v, err := parseInt(s, 32)
+		return protoreflect.ValueOfInt32(int32(v)), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		v, err := parseInt(s, 64)
+		return protoreflect.ValueOfInt64(v), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		v, err := parseUint(s, 32)
+		return protoreflect.ValueOfUint32(uint32(v)), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		v, err := parseUint(s, 64)
+		return protoreflect.ValueOfUint64(v), err
+	case protoreflect.FloatKind:
+		var v float64
+		err := config.ParseValue(s, &v)
+		return protoreflect.ValueOfFloat32(float32(v)), err
+	case protoreflect.DoubleKind:
+		var v float64
+		err := config.ParseValue(s, &v)
+		return protoreflect.ValueOfFloat64(v), err
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByName(protoreflect.Name(s)); ev != nil {
+			return protoreflect.ValueOfEnum(ev.Number()), nil
+		}
+		v, err := parseInt(s, 32)
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(v)), err
+	default:
+		return protoreflect.Value{}, fmt.Errorf("unsupported proto kind %s", fd.Kind())
+	}
+}
+
+// parseInt converts |s| to an int64 that fits in |bits|
+func parseInt(s string, bits int) (int64, error) {
+	var n int64
+	if err := config.ParseValue(s, &n); err != nil {
+		return 0, err
+	}
+	if bits < 64 && (n < -1<<(bits-1) || n >= 1<<(bits-1)) {
+		return 0, fmt.Errorf("value %d out of range for int%d", n, bits)
+	}
+	return n, nil
+}
+
+// parseUint converts |s| to a uint64 that fits in |bits|
+func parseUint(s string, bits int) (uint64, error) {
+	var n uint64
+	if err := config.ParseValue(s, &n); err != nil {
+		return 0, err
+	}
+	if bits < 64 && n >= 1<<bits {
+		return 0, fmt.Errorf("value %d out of range for uint%d", n, bits)
+	}
+	return n, nil
+}
+
+// ReadConfigProto loads the fields of |msg| from command-line args (precedence) or environment.
+// Flag names are the kebab-case of the proto field name and env names the screaming snake of
+// the flag name. Fields of nested messages are prefixed by the name of the message field and, as
+// with nested struct pointers in package config, are only traversed when already set.
+// Repeated and map fields are ignored.
+//
+// Well-known google.protobuf.Duration fields are parsed as a time.Duration.
+//
+// Only the env and flag layers are supported. The options of package config, e.g. WithEnvJSON and
+// WithStartupDump, and its tag-based validation do not apply to proto messages.
+func ReadConfigProto(msg proto.Message, opts ...Option) error {
+	o := options{flagset: flag.CommandLine, args: os.Args[1:]}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if msg == nil {
+		return fmt.Errorf("argument is a nil message")
+	}
+	if err := reflectMessage(msg.ProtoReflect(), "", o.flagset); err != nil {
+		return err
+	}
+	return o.flagset.Parse(o.args)
+}
+
+func reflectMessage(m protoreflect.Message, pfx string, flagset *flag.FlagSet) error {
+	fields := m.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if fd.IsList() || fd.IsMap() {
+			continue
+		}
+
+		flagName := pfx + strcase.ToKebab(string(fd.Name()))
+		envName := strcase.ToScreamingSnake(flagName)
+
+		var value flag.Value = &fieldValue{msg: m, fd: fd}
+		if fd.Kind() == protoreflect.MessageKind || fd.Kind() == protoreflect.GroupKind {
+			if fd.Message().FullName() == "google.protobuf.Duration" {
+				value = &durationValue{msg: m, fd: fd}
+			} else {
+				if !m.Has(fd) {
+					continue
+				}
+				if err := reflectMessage(m.Mutable(fd).Message(), flagName+"-", flagset); err != nil {
+					return fmt.Errorf("%w; %s: field failure", err, fd.Name())
+				}
+				continue
+			}
+		}
+
+		if env, ok := os.LookupEnv(envName); ok {
+			if err := value.Set(env); err != nil {
+				return fmt.Errorf("%w; env %s: field failure", err, envName)
+			}
+		}
+		flagset.Var(value, flagName, "")
+	}
+
+	return nil
+}
+
+// durationValue is a flag.Value setting a google.protobuf.Duration field from a time.Duration string
+type durationValue struct {
+	msg protoreflect.Message
+	fd  protoreflect.FieldDescriptor
+}
+
+func (v *durationValue) String() string {
+	if v.msg == nil || !v.msg.Has(v.fd) {
+		return ""
+	}
+	dm := v.msg.Get(v.fd).Message()
+	fields := dm.Descriptor().Fields()
+	secs := dm.Get(fields.ByName("seconds")).Int()
+	nanos := dm.Get(fields.ByName("nanos")).Int()
+	return (time.Duration(secs)*time.Second + time.Duration(nanos)).String()
+}
+
+func (v *durationValue) Set(s string) error {
+	var d time.Duration
+	if err := config.ParseValue(s, &d); err != nil {
+		return err
+	}
+	dm := v.msg.Mutable(v.fd).Message()
+	fields := dm.Descriptor().Fields()
+	dm.Set(fields.ByName("seconds"), protoreflect.ValueOfInt64(int64(d/time.Second)))
+	dm.Set(fields.ByName("nanos"), protoreflect.ValueOfInt32(int32(d%time.Second)))
+	return nil
+}
@@ -0,0 +1,125 @@
+package configproto
+
+import (
+	"flag"
+	"os"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	_ "google.golang.org/protobuf/types/known/durationpb"
+)
+
+// newServerConfig builds a dynamic message equivalent to:
+//
+//	message TLS { string cert_file = 1; }
+//	message ServerConfig {
+//	  string listen_addr = 1;
+//	  int32 max_conns = 2;
+//	  bool debug = 3;
+//	  google.protobuf.Duration timeout = 4;
+//	  TLS tls = 5;
+//	  repeated string peers = 6;
+//	}
+func newServerConfig() protoreflect.Message {
+	opt := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+	rep := descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+	field := func(name string, num int32, typ descriptorpb.FieldDescriptorProto_Type, label descriptorpb.FieldDescriptorProto_Label, typeName string) *descriptorpb.FieldDescriptorProto {
+		f := &descriptorpb.FieldDescriptorProto{
+			Name:   proto.String(name),
+			Number: proto.Int32(num),
+			Type:   typ.Enum(),
+			Label:  label.Enum(),
+		}
+		if typeName != "" {
+			f.TypeName = proto.String(typeName)
+		}
+		return f
+	}
+	fdp := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("test/config.proto"),
+		Package:    proto.String("test"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"google/protobuf/duration.proto"},
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("TLS"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("cert_file", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, opt, ""),
+				},
+			},
+			{
+				Name: proto.String("ServerConfig"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("listen_addr", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, opt, ""),
+					field("max_conns", 2, descriptorpb.FieldDescriptorProto_TYPE_INT32, opt, ""),
+					field("debug", 3, descriptorpb.FieldDescriptorProto_TYPE_BOOL, opt, ""),
+					field("timeout", 4, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, opt, ".google.protobuf.Duration"),
+					field("tls", 5, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, opt, ".test.TLS"),
+					field("peers", 6, descriptorpb.FieldDescriptorProto_TYPE_STRING, rep, ""),
+				},
+			},
+		},
+	}
+	fd, err := protodesc.NewFile(fdp, protoregistry.GlobalFiles)
+	if err != nil {
+		panic(err)
+	}
+	return dynamicpb.NewMessage(fd.Messages().ByName("ServerConfig"))
+}
+
+func TestReadConfigProto(t *testing.T) {
+	Convey("Scalar fields", t, func() {
+		m := newServerConfig()
+		fields := m.Descriptor().Fields()
+		m.Set(fields.ByName("listen_addr"), protoreflect.ValueOfString(":80"))
+
+		os.Setenv("MAX_CONNS", "10")
+		os.Setenv("TIMEOUT", "30s")
+		fs := flag.NewFlagSet("cmd", flag.ContinueOnError)
+		err := ReadConfigProto(m.Interface(), WithFlagSet(fs), WithArgs([]string{"-debug", "-max-conns", "20"}))
+		So(err, ShouldBeNil)
+		So(m.Get(fields.ByName("listen_addr")).String(), ShouldEqual, ":80")
+		So(m.Get(fields.ByName("max_conns")).Int(), ShouldEqual, 20)
+		So(m.Get(fields.ByName("debug")).Bool(), ShouldBeTrue)
+		So(fs.Lookup("timeout").Value.String(), ShouldEqual, (30 * time.Second).String())
+		So(fs.Lookup("peers"), ShouldBeNil)
+		So(fs.Lookup("tls-cert-file"), ShouldBeNil) // unset nested message is not traversed
+		os.Unsetenv("MAX_CONNS")
+		os.Unsetenv("TIMEOUT")
+	})
+
+	Convey("Nested messages", t, func() {
+		m := newServerConfig()
+		fields := m.Descriptor().Fields()
+		tls := m.Mutable(fields.ByName("tls")).Message()
+
+		os.Setenv("TLS_CERT_FILE", "/etc/cert.pem")
+		fs := flag.NewFlagSet("cmd", flag.ContinueOnError)
+		err := ReadConfigProto(m.Interface(), WithFlagSet(fs), WithArgs([]string{}))
+		So(err, ShouldBeNil)
+		So(tls.Get(tls.Descriptor().Fields().ByName("cert_file")).String(), ShouldEqual, "/etc/cert.pem")
+		os.Unsetenv("TLS_CERT_FILE")
+	})
+
+	Convey("Bad env value", t, func() {
+		m := newServerConfig()
+		os.Setenv("MAX_CONNS", "many")
+		fs := flag.NewFlagSet("cmd", flag.ContinueOnError)
+		err := ReadConfigProto(m.Interface(), WithFlagSet(fs), WithArgs([]string{}))
+		So(err, ShouldNotBeNil)
+
+		os.Setenv("MAX_CONNS", "3000000000") // overflows int32
+		fs = flag.NewFlagSet("cmd", flag.ContinueOnError)
+		err = ReadConfigProto(m.Interface(), WithFlagSet(fs), WithArgs([]string{}))
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "MAX_CONNS")
+		os.Unsetenv("MAX_CONNS")
+	})
+}
@@ -0,0 +1,10 @@
+module github.com/dsggregory/config/configproto
+
+go 1.15
+
+require (
+	github.com/dsggregory/config v0.0.0-20261014143508-a8205278d21d
+	github.com/iancoleman/strcase v0.1.3
+	github.com/smartystreets/goconvey v1.6.4
+	google.golang.org/protobuf v1.28.1
+)